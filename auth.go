@@ -0,0 +1,524 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthProvider authenticates a request, returning the authenticated
+// principal (for logging) on success.
+type AuthProvider interface {
+	Name() string
+	Authenticate(r *http.Request) (principal string, ok bool)
+}
+
+// BearerTokenProvider checks for a static bearer token, typically sourced
+// from an env var so it can be rotated without a code change.
+type BearerTokenProvider struct {
+	token string
+}
+
+// NewBearerTokenProvider creates a provider that accepts `Authorization:
+// Bearer <token>` when token matches exactly (compared in constant time).
+func NewBearerTokenProvider(token string) *BearerTokenProvider {
+	return &BearerTokenProvider{token: token}
+}
+
+func (p *BearerTokenProvider) Name() string { return "bearer" }
+
+func (p *BearerTokenProvider) Authenticate(r *http.Request) (string, bool) {
+	if p.token == "" {
+		return "", false
+	}
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		return "", false
+	}
+	candidate := strings.TrimPrefix(auth, prefix)
+	if subtle.ConstantTimeCompare([]byte(candidate), []byte(p.token)) == 1 {
+		return "bearer-token", true
+	}
+	return "", false
+}
+
+// BasicAuthProvider checks HTTP Basic credentials against a single
+// configured username/password pair.
+type BasicAuthProvider struct {
+	username, password string
+}
+
+// NewBasicAuthProvider creates a provider for a single operator account.
+func NewBasicAuthProvider(username, password string) *BasicAuthProvider {
+	return &BasicAuthProvider{username: username, password: password}
+}
+
+func (p *BasicAuthProvider) Name() string { return "basic" }
+
+func (p *BasicAuthProvider) Authenticate(r *http.Request) (string, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(p.username)) == 1
+	passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(p.password)) == 1
+	if userMatch && passMatch {
+		return user, true
+	}
+	return "", false
+}
+
+// MTLSProvider accepts a request whose client certificate chains to a
+// configured CA pool.
+type MTLSProvider struct {
+	pool *x509.CertPool
+}
+
+// NewMTLSProvider loads CA certificates from caPEM (PEM-encoded, possibly
+// containing multiple certs) to validate client certs against.
+func NewMTLSProvider(caPEM []byte) (*MTLSProvider, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("auth: no valid CA certificates found in PEM input")
+	}
+	return &MTLSProvider{pool: pool}, nil
+}
+
+func (p *MTLSProvider) Name() string { return "mtls" }
+
+func (p *MTLSProvider) Authenticate(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+	cert := r.TLS.PeerCertificates[0]
+	opts := x509.VerifyOptions{
+		Roots:         p.pool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, c := range r.TLS.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return "", false
+	}
+	return cert.Subject.CommonName, true
+}
+
+// jwksKey is the subset of a JWK we need to verify RS256 signatures.
+type jwksKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksDocument struct {
+	Keys []jwksKey `json:"keys"`
+}
+
+// OIDCProvider validates RS256-signed JWTs against keys published at a JWKS
+// URL, refetching the key set when an unknown `kid` is seen (bounded by
+// minRefreshInterval to avoid hammering the IdP).
+type OIDCProvider struct {
+	jwksURL  string
+	issuer   string
+	audience string
+	client   *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	lastFetched time.Time
+}
+
+// NewOIDCProvider creates a provider that validates tokens issued by issuer
+// using keys published at jwksURL. audience may be empty to skip the `aud`
+// check.
+func NewOIDCProvider(jwksURL, issuer, audience string) *OIDCProvider {
+	return &OIDCProvider{
+		jwksURL:  jwksURL,
+		issuer:   issuer,
+		audience: audience,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		keys:     map[string]*rsa.PublicKey{},
+	}
+}
+
+func (p *OIDCProvider) Name() string { return "oidc" }
+
+func (p *OIDCProvider) Authenticate(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		return "", false
+	}
+	token := strings.TrimPrefix(auth, prefix)
+	claims, err := p.verify(token)
+	if err != nil {
+		return "", false
+	}
+	sub, _ := claims["sub"].(string)
+	return sub, true
+}
+
+func (p *OIDCProvider) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed JWT")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("oidc: unsupported alg %q", header.Alg)
+	}
+
+	key, err := p.keyFor(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signedPart := parts[0] + "." + parts[1]
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	hashed := sha256.Sum256([]byte(signedPart))
+	if err := rsaVerifyPKCS1v15(key, hashed[:], sig); err != nil {
+		return nil, err
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payloadJSON, &claims); err != nil {
+		return nil, err
+	}
+	// exp is mandatory: a token with no (or non-numeric) expiry must not be
+	// treated as valid forever.
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("oidc: token has no exp claim")
+	}
+	now := time.Now().Unix()
+	if now > int64(exp) {
+		return nil, errors.New("oidc: token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf) {
+		return nil, errors.New("oidc: token not yet valid")
+	}
+	if p.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != p.issuer {
+			return nil, errors.New("oidc: issuer mismatch")
+		}
+	}
+	if p.audience != "" && !audienceContains(claims["aud"], p.audience) {
+		return nil, errors.New("oidc: audience mismatch")
+	}
+	return claims, nil
+}
+
+// audienceContains reports whether aud (a JWT `aud` claim, either a single
+// string or an array of strings per RFC 7519) contains want.
+func audienceContains(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// minRefreshInterval bounds how often an unrecognized kid can trigger a
+// JWKS refetch, so a flood of bogus tokens can't be used to hammer the IdP.
+const minRefreshInterval = time.Minute
+
+func (p *OIDCProvider) keyFor(kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	sinceFetch := time.Since(p.lastFetched)
+	p.mu.Unlock()
+	if ok && sinceFetch <= minRefreshInterval {
+		return key, nil
+	}
+	if !p.lastFetched.IsZero() && sinceFetch <= minRefreshInterval {
+		// Known-stale key set, but we refetched too recently to justify
+		// hitting the IdP again for yet another unknown kid.
+		return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+	}
+	if err := p.refreshKeys(); err != nil {
+		if ok {
+			return key, nil // serve the stale key rather than fail open/closed on a transient fetch error
+		}
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok = p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (p *OIDCProvider) refreshKeys() error {
+	resp, err := p.client.Get(p.jwksURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: jwks fetch returned %s", resp.Status)
+	}
+	var doc jwksDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := jwkToRSAPublicKey(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.lastFetched = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func jwkToRSAPublicKey(k jwksKey) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	n := new(big.Int).SetBytes(nBytes)
+	e := new(big.Int).SetBytes(eBytes)
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func rsaVerifyPKCS1v15(pub *rsa.PublicKey, hashed, sig []byte) error {
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed, sig)
+}
+
+// authProvidersFromEnv wires up whichever providers have their env vars
+// set; operators can enable more than one (e.g. bearer token for scripts,
+// OIDC for humans) and any one succeeding authenticates the request.
+func authProvidersFromEnv() []AuthProvider {
+	var providers []AuthProvider
+
+	if token := os.Getenv("AUTH_BEARER_TOKEN"); token != "" {
+		providers = append(providers, NewBearerTokenProvider(token))
+	}
+	if user, pass := os.Getenv("AUTH_BASIC_USER"), os.Getenv("AUTH_BASIC_PASS"); user != "" && pass != "" {
+		providers = append(providers, NewBasicAuthProvider(user, pass))
+	}
+	if caFile := os.Getenv("AUTH_MTLS_CA_FILE"); caFile != "" {
+		if pemBytes, err := os.ReadFile(caFile); err == nil {
+			if p, err := NewMTLSProvider(pemBytes); err == nil {
+				providers = append(providers, p)
+			}
+		}
+	}
+	if jwksURL := os.Getenv("AUTH_OIDC_JWKS_URL"); jwksURL != "" {
+		providers = append(providers, NewOIDCProvider(jwksURL, os.Getenv("AUTH_OIDC_ISSUER"), os.Getenv("AUTH_OIDC_AUDIENCE")))
+	}
+	return providers
+}
+
+// AuthPolicy maps route prefixes to the providers allowed to authenticate
+// them. Routes with no matching prefix are left open.
+type AuthPolicy struct {
+	protected []protectedRoute
+}
+
+type protectedRoute struct {
+	prefix    string
+	providers []AuthProvider
+}
+
+// NewAuthPolicy builds a policy that requires auth (via any of providers)
+// for routes under each of prefixes.
+func NewAuthPolicy(providers []AuthProvider, prefixes ...string) *AuthPolicy {
+	policy := &AuthPolicy{}
+	for _, prefix := range prefixes {
+		policy.protected = append(policy.protected, protectedRoute{prefix: prefix, providers: providers})
+	}
+	return policy
+}
+
+// requiredFor reports whether path falls under a protected prefix, and if
+// so, which providers may satisfy it (possibly none, if the operator never
+// configured any — see authMiddleware, which fails closed in that case).
+func (p *AuthPolicy) requiredFor(path string) (providers []AuthProvider, protected bool) {
+	for _, r := range p.protected {
+		if strings.HasPrefix(path, r.prefix) {
+			return r.providers, true
+		}
+	}
+	return nil, false
+}
+
+// authMiddleware enforces policy ahead of handler: if path requires auth and
+// none of its providers accept the request, it responds 401 and returns
+// without calling next. A protected path with zero configured providers is
+// treated as misconfigured and also rejected, rather than silently left
+// open — an operator who forgets to set any AUTH_* env var should see every
+// request to /debug/pprof or /toggle-ready fail, not pass through unchecked.
+func authMiddleware(policy *AuthPolicy, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		providers, protected := policy.requiredFor(r.URL.Path)
+		if !protected {
+			next(w, r)
+			return
+		}
+		for _, p := range providers {
+			if _, ok := p.Authenticate(r); ok {
+				next(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="deepsight"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}
+}
+
+// tokenBucket is a simple token-bucket rate limiter for one key (client IP).
+type tokenBucket struct {
+	mu         sync.Mutex
+	tokens     float64
+	capacity   float64
+	refillRate float64 // tokens per second
+	lastRefill time.Time
+}
+
+func newTokenBucket(capacity, refillRate float64) *tokenBucket {
+	return &tokenBucket{tokens: capacity, capacity: capacity, refillRate: refillRate, lastRefill: time.Now()}
+}
+
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	b.lastRefill = now
+	b.tokens += elapsed * b.refillRate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) idleSince(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.lastRefill)
+}
+
+// bucketIdleTTL is how long a per-IP bucket can go unused before it's
+// swept, so a flood of one-off source addresses can't grow the map forever.
+const bucketIdleTTL = 10 * time.Minute
+
+// RateLimiter buckets requests per client IP to blunt brute-force attempts
+// against auth-protected endpoints.
+type RateLimiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*tokenBucket
+	capacity   float64
+	refillRate float64
+}
+
+// NewRateLimiter creates a limiter allowing burstCapacity requests
+// immediately, then refillPerSecond requests/sec thereafter, per client IP.
+// It starts a background goroutine that evicts buckets idle longer than
+// bucketIdleTTL.
+func NewRateLimiter(burstCapacity, refillPerSecond float64) *RateLimiter {
+	rl := &RateLimiter{
+		buckets:    make(map[string]*tokenBucket),
+		capacity:   burstCapacity,
+		refillRate: refillPerSecond,
+	}
+	go rl.evictLoop()
+	return rl
+}
+
+func (rl *RateLimiter) evictLoop() {
+	ticker := time.NewTicker(bucketIdleTTL)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		rl.mu.Lock()
+		for key, b := range rl.buckets {
+			if b.idleSince(now) > bucketIdleTTL {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+func (rl *RateLimiter) allow(key string) bool {
+	rl.mu.Lock()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = newTokenBucket(rl.capacity, rl.refillRate)
+		rl.buckets[key] = b
+	}
+	rl.mu.Unlock()
+	return b.allow()
+}
+
+// rateLimitMiddleware rejects with 429 once a client IP exceeds its bucket,
+// ahead of authentication so brute-force attempts get throttled before any
+// credential comparison happens.
+func rateLimitMiddleware(rl *RateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !rl.allow(TrustedRemoteIP(r)) {
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
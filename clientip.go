@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// proxyProtocolEnabled is set once in main() when PROXY_PROTOCOL is turned
+// on. When true, the connection's RemoteAddr has already been decoded from
+// a trusted PROXY protocol v2 header (see proxyproto.go) and is preferred
+// over client-supplied headers, which can be spoofed.
+var proxyProtocolEnabled bool
+
+// ClientIP resolves the best-known client address for r: the PROXY
+// protocol-decoded source when enabled, otherwise X-Forwarded-For /
+// X-Real-IP, falling back to the raw connection's remote address.
+func ClientIP(r *http.Request) string {
+	if proxyProtocolEnabled {
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			return host
+		}
+		return r.RemoteAddr
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		parts := strings.Split(xff, ",")
+		return strings.TrimSpace(parts[0])
+	}
+	if xr := r.Header.Get("X-Real-IP"); xr != "" {
+		return xr
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// TrustedRemoteIP returns the connection's actual source address: the
+// PROXY protocol-decoded source when enabled, otherwise the raw TCP peer
+// address. Unlike ClientIP, it never trusts client-supplied headers, so
+// it's safe to use as a rate-limiting key (X-Forwarded-For can be set to
+// anything by the client making the request).
+func TrustedRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
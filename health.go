@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CheckFunc performs a single health check and returns a non-nil error if
+// the thing it's checking is unhealthy.
+type CheckFunc func(ctx context.Context) error
+
+// Check describes a named health check registered against a HealthRegistry.
+type Check struct {
+	Name string
+	Fn   CheckFunc
+
+	// Timeout bounds how long Fn is allowed to run; zero means 1s.
+	Timeout time.Duration
+
+	// Critical marks a readiness check whose failure should also fail
+	// liveness (e.g. a deadlocked dependency, not just a slow one).
+	Critical bool
+
+	// CacheTTL lets expensive checks (e.g. a DB ping) be reused across
+	// scrapes instead of re-run on every request; zero disables caching.
+	CacheTTL time.Duration
+}
+
+type checkState struct {
+	check   Check
+	mu      sync.Mutex
+	lastRun time.Time
+	lastErr error
+	lastDur time.Duration
+}
+
+// CheckStatus is the JSON-serializable outcome of a single check.
+type CheckStatus struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// HealthRegistry holds the named checks backing /livez and /readyz.
+type HealthRegistry struct {
+	mu        sync.Mutex
+	liveness  []*checkState
+	readiness []*checkState
+}
+
+// NewHealthRegistry creates an empty health registry.
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{}
+}
+
+// RegisterLiveness adds a check that gates /livez.
+func (h *HealthRegistry) RegisterLiveness(c Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, &checkState{check: c})
+}
+
+// RegisterReadiness adds a check that gates /readyz. Critical readiness
+// checks are also evaluated as part of /livez.
+func (h *HealthRegistry) RegisterReadiness(c Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, &checkState{check: c})
+}
+
+// run evaluates a single check, respecting its timeout and cache TTL.
+func (s *checkState) run(ctx context.Context) (bool, error, time.Duration) {
+	s.mu.Lock()
+	if s.check.CacheTTL > 0 && time.Since(s.lastRun) < s.check.CacheTTL {
+		err, dur := s.lastErr, s.lastDur
+		s.mu.Unlock()
+		return err == nil, err, dur
+	}
+	s.mu.Unlock()
+
+	timeout := s.check.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := s.check.Fn(cctx)
+	dur := time.Since(start)
+
+	s.mu.Lock()
+	s.lastRun = time.Now()
+	s.lastErr = err
+	s.lastDur = dur
+	s.mu.Unlock()
+
+	return err == nil, err, dur
+}
+
+// evaluate runs a set of checks and returns overall pass/fail plus a
+// per-check breakdown (populated only when verbose is true, matching
+// kube-apiserver's `?verbose=1` health endpoints).
+func evaluate(ctx context.Context, checks []*checkState, verbose bool) (bool, map[string]CheckStatus) {
+	ok := true
+	var details map[string]CheckStatus
+	if verbose {
+		details = make(map[string]CheckStatus, len(checks))
+	}
+	for _, s := range checks {
+		passed, err, dur := s.run(ctx)
+		if !passed {
+			ok = false
+		}
+		if verbose {
+			status := CheckStatus{Status: "ok", DurationMS: dur.Milliseconds()}
+			if err != nil {
+				status.Status = "error"
+				status.Error = err.Error()
+			}
+			details[s.check.Name] = status
+		}
+	}
+	return ok, details
+}
+
+// livezHandler serves liveness: only checks marked for liveness, plus any
+// Critical readiness checks (a critical dependency being down means the
+// process itself should be considered unhealthy, not merely not-ready).
+func (h *HealthRegistry) livezHandler(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	checks := append([]*checkState{}, h.liveness...)
+	for _, s := range h.readiness {
+		if s.check.Critical {
+			checks = append(checks, s)
+		}
+	}
+	h.mu.Unlock()
+
+	h.serve(w, r, checks)
+}
+
+// readyzHandler serves readiness: all registered readiness checks, plus the
+// manual /toggle-ready override, which always wins when forced off.
+func (h *HealthRegistry) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	checks := append([]*checkState{}, h.readiness...)
+	h.mu.Unlock()
+
+	if atomic.LoadInt32(&readyFlag) == 0 {
+		verbose := r.URL.Query().Get("verbose") == "1"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		body := map[string]interface{}{"status": "fail"}
+		if verbose {
+			body["reason"] = "forced not-ready via /toggle-ready"
+		}
+		_ = json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	h.serve(w, r, checks)
+}
+
+func (h *HealthRegistry) serve(w http.ResponseWriter, r *http.Request, checks []*checkState) {
+	verbose := r.URL.Query().Get("verbose") == "1"
+	ok, details := evaluate(r.Context(), checks, verbose)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	body := map[string]interface{}{"status": statusString(ok)}
+	if verbose {
+		body["checks"] = details
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func statusString(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "fail"
+}
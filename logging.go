@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestIDKey/loggerKey are unexported context keys so only this package
+// can set or read the values, following the usual Go convention.
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+)
+
+// Sink is anywhere an access log entry can be written: stdout, a file, a
+// syslog daemon, or an HTTP log shipper. Implementations must be safe for
+// concurrent use.
+type Sink interface {
+	Write(entry map[string]interface{}) error
+}
+
+// writerSink formats entries as logfmt or JSON lines and writes them to an
+// io.Writer. It's the Sink used for stdout and plain files.
+type writerSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string // "json" or "logfmt"
+}
+
+// NewWriterSink creates a Sink that serializes to w using format ("json" or
+// "logfmt"; anything else falls back to "logfmt").
+func NewWriterSink(w io.Writer, format string) Sink {
+	return &writerSink{w: w, format: format}
+}
+
+func (s *writerSink) Write(entry map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.format {
+	case "json":
+		enc := jsonLine(entry)
+		_, err := s.w.Write(enc)
+		return err
+	default:
+		_, err := s.w.Write(logfmtLine(entry))
+		return err
+	}
+}
+
+// jsonLine serializes entry as a single JSON object followed by a newline.
+// It marshals each key/value with encoding/json rather than hand-quoting
+// them, so control bytes or invalid UTF-8 in attacker-controlled fields
+// (request path, headers, ...) come out as valid JSON escapes instead of
+// corrupting the log stream.
+func jsonLine(entry map[string]interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range orderedKeys(entry) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		keyJSON, err := json.Marshal(k)
+		if err != nil {
+			continue
+		}
+		valJSON, err := json.Marshal(entry[k])
+		if err != nil {
+			valJSON, _ = json.Marshal(fmt.Sprintf("%v", entry[k]))
+		}
+		b.Write(keyJSON)
+		b.WriteByte(':')
+		b.Write(valJSON)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func logfmtLine(entry map[string]interface{}) []byte {
+	var b strings.Builder
+	for i, k := range orderedKeys(entry) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		val := fmt.Sprintf("%v", entry[k])
+		if strings.ContainsAny(val, " \"=") {
+			val = fmt.Sprintf("%q", val)
+		}
+		fmt.Fprintf(&b, "%s=%s", k, val)
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// orderedKeys returns a stable, readable ordering for the common access-log
+// fields, followed by any other keys in unspecified (map iteration) order.
+func orderedKeys(entry map[string]interface{}) []string {
+	preferred := []string{"time", "level", "msg", "request_id", "method", "path", "status", "bytes", "duration_ms", "remote_ip"}
+	keys := make([]string, 0, len(entry))
+	seen := make(map[string]bool, len(entry))
+	for _, k := range preferred {
+		if _, ok := entry[k]; ok {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+	for k := range entry {
+		if !seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Logger carries a Sink plus structured fields that get merged into every
+// entry it emits, so handlers can attach request-scoped context (request ID,
+// remote IP, ...) once and reuse it for any additional log lines.
+type Logger struct {
+	sink   Sink
+	fields map[string]interface{}
+}
+
+// NewLogger creates a root Logger writing to sink.
+func NewLogger(sink Sink) *Logger {
+	return &Logger{sink: sink, fields: map[string]interface{}{}}
+}
+
+// With returns a child Logger with the given fields merged in.
+func (l *Logger) With(fields map[string]interface{}) *Logger {
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{sink: l.sink, fields: merged}
+}
+
+// Info emits a log line at info level with an optional extra field set.
+func (l *Logger) Info(msg string, fields map[string]interface{}) {
+	l.emit("info", msg, fields)
+}
+
+func (l *Logger) emit(level, msg string, fields map[string]interface{}) {
+	entry := make(map[string]interface{}, len(l.fields)+len(fields)+3)
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	if err := l.sink.Write(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "logging: sink write failed: %v\n", err)
+	}
+}
+
+// LoggerFromContext returns the request-scoped Logger, or a fallback logger
+// writing logfmt to stderr if none was attached (e.g. in tests).
+func LoggerFromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return l
+	}
+	return NewLogger(NewWriterSink(os.Stderr, "logfmt"))
+}
+
+// RequestIDFromContext returns the request ID assigned by loggingMiddleware,
+// or "" if called outside a request.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// logFormatFromEnv reads LOG_FORMAT ("json" or "logfmt", default "logfmt").
+func logFormatFromEnv() string {
+	switch strings.ToLower(os.Getenv("LOG_FORMAT")) {
+	case "json":
+		return "json"
+	default:
+		return "logfmt"
+	}
+}
+
+// loggingMiddleware assigns/propagates an X-Request-ID, logs one structured
+// access-log line per request, and threads a context-carried *Logger into
+// the handler so it can add its own fields.
+func loggingMiddleware(root *Logger, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqID := r.Header.Get("X-Request-ID")
+		if reqID == "" {
+			reqID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", reqID)
+
+		remoteIP := ClientIP(r)
+		reqLogger := root.With(map[string]interface{}{
+			"request_id": reqID,
+			"remote_ip":  remoteIP,
+		})
+
+		ctx := context.WithValue(r.Context(), requestIDKey, reqID)
+		ctx = context.WithValue(ctx, loggerKey, reqLogger)
+		r = r.WithContext(ctx)
+
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next(sr, r)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+
+		reqLogger.Info("request handled", map[string]interface{}{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      sr.status,
+			"bytes":       sr.size,
+			"duration_ms": time.Since(start).Milliseconds(),
+		})
+	}
+}
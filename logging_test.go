@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestJSONLineEscapesControlBytes guards against the hand-rolled %q
+// formatter, which emitted Go-quoted (not JSON-quoted) escapes for control
+// bytes and invalid UTF-8 in attacker-controlled fields like the request
+// path.
+func TestJSONLineEscapesControlBytes(t *testing.T) {
+	entry := map[string]interface{}{
+		"time":   "2026-07-26T00:00:00Z",
+		"msg":    "request handled",
+		"path":   "/foo\x01bar",
+		"status": 200,
+	}
+
+	line := jsonLine(entry)
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(line, &decoded); err != nil {
+		t.Fatalf("jsonLine produced invalid JSON: %v\noutput: %s", err, line)
+	}
+	if decoded["path"] != "/foo\x01bar" {
+		t.Fatalf("path round-tripped incorrectly: got %q", decoded["path"])
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -26,18 +28,26 @@ var tmpl = template.Must(template.ParseFiles("templates/index.html"))
 // Server holds runtime state and simple in-memory metrics
 type Server struct {
 	startTime time.Time
-	reqTotal  uint64
 	// last request duration in ms
 	lastReqMs int64
 
+	// registry backs both the /metrics endpoint and the dashboard's request
+	// total, so the two never disagree on how many requests have landed.
+	registry       *Registry
+	dashboardTotal *counterVec
+
 	// sliding window of per-second counts (last 60 seconds)
 	mu      sync.Mutex
 	buckets [60]uint64
 	idx     int
 }
 
-func NewServer() *Server {
-	s := &Server{startTime: time.Now()}
+func NewServer(reg *Registry) *Server {
+	s := &Server{
+		startTime:      time.Now(),
+		registry:       reg,
+		dashboardTotal: reg.Counter("deepsight_dashboard_requests_total", "Total requests recorded by the legacy dashboard counter."),
+	}
 	go s.rotateLoop()
 	return s
 }
@@ -54,12 +64,16 @@ func (s *Server) rotateLoop() {
 }
 
 func (s *Server) recordRequest() {
-	atomic.AddUint64(&s.reqTotal, 1)
+	s.dashboardTotal.Inc()
 	s.mu.Lock()
 	s.buckets[s.idx]++
 	s.mu.Unlock()
 }
 
+func (s *Server) requestsTotal() uint64 {
+	return s.dashboardTotal.value()
+}
+
 func (s *Server) requestsPerMinute() uint64 {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -104,21 +118,8 @@ func (s *Server) dashboardHandler(w http.ResponseWriter, r *http.Request) {
 		heights[i] = h
 	}
 
-	// client info (respect X-Forwarded-For / X-Real-IP when behind proxies/ingress)
-	clientIP := func(r *http.Request) string {
-		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-			parts := strings.Split(xff, ",")
-			return strings.TrimSpace(parts[0])
-		}
-		if xr := r.Header.Get("X-Real-IP"); xr != "" {
-			return xr
-		}
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			return r.RemoteAddr
-		}
-		return host
-	}(r)
+	// client info (respects PROXY protocol / X-Forwarded-For / X-Real-IP; see clientip.go)
+	clientIP := ClientIP(r)
 
 	// capture a small set of headers to show (omit sensitive ones)
 	hdrs := map[string]string{}
@@ -129,10 +130,16 @@ func (s *Server) dashboardHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	traceID := ""
+	if sc, ok := SpanFromContext(r.Context()); ok {
+		traceID = hex.EncodeToString(sc.TraceID[:])
+	}
+
 	data := map[string]interface{}{
+		"TraceID":        traceID,
 		"StartTime":      s.startTime.Format(time.RFC3339),
 		"Uptime":         time.Since(s.startTime).Truncate(time.Second).String(),
-		"RequestsTotal":  atomic.LoadUint64(&s.reqTotal),
+		"RequestsTotal":  s.requestsTotal(),
 		"RequestsPerMin": s.requestsPerMinute(),
 		"Hostname":       hostname,
 		"GoVersion":      runtime.Version(),
@@ -231,16 +238,11 @@ func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(out)
 }
 
-func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
-	s.recordRequest()
-	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
-	uptime := time.Since(s.startTime).Seconds()
-	fmt.Fprintf(w, "uptime_seconds %d\n", int64(uptime))
-	fmt.Fprintf(w, "requests_total %d\n", atomic.LoadUint64(&s.reqTotal))
-	fmt.Fprintf(w, "requests_per_min %d\n", s.requestsPerMinute())
-	fmt.Fprintf(w, "last_request_ms %d\n", atomic.LoadInt64(&s.lastReqMs))
-	fmt.Fprintf(w, "goroutines %d\n", runtime.NumGoroutine())
-}
+// metricsHandler has been superseded by srv.registry.Handler(), which serves
+// uptime, goroutine count and the request total (under new metric names) plus
+// per-route counters and histograms in Prometheus exposition format. The old
+// requests_per_min and last_request_ms series are not exported. Kept
+// registered under the same path in main().
 
 func main() {
 	// If built with modules that disable symbol table info, enable it for stack traces.
@@ -253,22 +255,58 @@ func main() {
 		}
 	}
 
-	srv := NewServer()
+	registry := NewRegistry()
+	uptimeGauge := registry.Gauge("process_uptime_seconds", "Seconds since the process started.")
+	goroutineGauge := registry.Gauge("go_goroutines", "Number of goroutines currently running.")
+
+	srv := NewServer(registry)
+
+	registry.RegisterCollector(func() {
+		uptimeGauge.Set(int64(time.Since(srv.startTime).Seconds()))
+		goroutineGauge.Set(int64(runtime.NumGoroutine()))
+	})
+
+	rootLogger := NewLogger(NewWriterSink(os.Stdout, logFormatFromEnv()))
+
+	health := NewHealthRegistry()
+	health.RegisterLiveness(Check{
+		Name: "goroutine_count",
+		Fn: func(ctx context.Context) error {
+			if n := runtime.NumGoroutine(); n > 10000 {
+				return fmt.Errorf("goroutine count %d exceeds threshold", n)
+			}
+			return nil
+		},
+		Timeout: 500 * time.Millisecond,
+	})
+
+	tracer := NewTracer("deepsight", exporterFromEnv("deepsight"))
+
+	authPolicy := NewAuthPolicy(authProvidersFromEnv(), "/debug/pprof", "/toggle-ready")
+	rateLimiter := NewRateLimiter(20, 5) // 20-request burst, 5 req/s refill, per client IP
+
+	route := func(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+		guarded := rateLimitMiddleware(rateLimiter, authMiddleware(authPolicy, handler))
+		traced := tracingMiddleware(tracer, pattern, metricsMiddleware(registry, pattern, guarded))
+		mux.HandleFunc(pattern, loggingMiddleware(rootLogger, traced))
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", srv.dashboardHandler)
-	mux.HandleFunc("/health", srv.healthHandler)
-	mux.HandleFunc("/metrics", srv.metricsHandler)
-	mux.HandleFunc("/ready", srv.readyHandler)
-	mux.HandleFunc("/toggle-ready", srv.toggleReadyHandler)
-	mux.HandleFunc("/version", srv.versionHandler)
-
-	// pprof endpoints (useful for debugging)
-	mux.HandleFunc("/debug/pprof/", pprof.Index)
-	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	route(mux, "/", srv.dashboardHandler)
+	route(mux, "/health", srv.healthHandler)
+	route(mux, "/ready", srv.readyHandler)
+	route(mux, "/toggle-ready", srv.toggleReadyHandler)
+	route(mux, "/version", srv.versionHandler)
+	route(mux, "/livez", health.livezHandler)
+	route(mux, "/readyz", health.readyzHandler)
+	route(mux, "/metrics", registry.Handler().ServeHTTP)
+
+	// pprof endpoints (useful for debugging; gated by authPolicy above)
+	route(mux, "/debug/pprof/", pprof.Index)
+	route(mux, "/debug/pprof/cmdline", pprof.Cmdline)
+	route(mux, "/debug/pprof/profile", pprof.Profile)
+	route(mux, "/debug/pprof/symbol", pprof.Symbol)
+	route(mux, "/debug/pprof/trace", pprof.Trace)
 
 	// serve static assets
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
@@ -279,23 +317,44 @@ func main() {
 		Handler: mux,
 	}
 
+	tlsConfig, err := tlsConfigFromEnv()
+	if err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+	server.TLSConfig = tlsConfig
+
+	proxyProtocolEnabled = os.Getenv("PROXY_PROTOCOL") == "1" || strings.EqualFold(os.Getenv("PROXY_PROTOCOL"), "true")
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("listen %s: %v", addr, err)
+	}
+	if proxyProtocolEnabled {
+		listener = NewProxyProtoListener(listener)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
 	// graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		log.Printf("starting deepSight on %s\n", addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		rootLogger.Info("starting deepSight", map[string]interface{}{
+			"addr": addr, "tls": tlsConfig != nil, "proxy_protocol": proxyProtocolEnabled,
+		})
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("server failed: %v", err)
 		}
 	}()
 
 	<-stop
-	log.Printf("shutting down server...")
+	rootLogger.Info("shutting down server", nil)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("graceful shutdown failed: %v", err)
 	}
-	log.Printf("server stopped")
+	rootLogger.Info("server stopped", nil)
 }
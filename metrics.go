@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry is a minimal Prometheus-compatible metrics registry. It avoids a
+// dependency on the official client library by implementing just enough of
+// the exposition format (text version 0.0.4) to be scraped directly.
+type Registry struct {
+	mu         sync.Mutex
+	counters   map[string]*counterVec
+	histograms map[string]*histogramVec
+	gauges     map[string]*gaugeVec
+	collectors []func()
+}
+
+// NewRegistry creates an empty metrics registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]*counterVec),
+		histograms: make(map[string]*histogramVec),
+		gauges:     make(map[string]*gaugeVec),
+	}
+}
+
+// RegisterCollector adds a function that is invoked immediately before each
+// scrape, letting callers refresh gauges (uptime, goroutine count, ...) from
+// live process state instead of tracking them on every request.
+func (r *Registry) RegisterCollector(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, fn)
+}
+
+type counterVec struct {
+	help   string
+	labels []string
+	values map[string]*uint64val
+}
+
+type gaugeVec struct {
+	help   string
+	labels []string
+	values map[string]*int64val
+}
+
+type histogramVec struct {
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogramVal
+}
+
+type uint64val struct {
+	mu sync.Mutex
+	v  uint64
+}
+
+type int64val struct {
+	mu sync.Mutex
+	v  int64
+}
+
+type histogramVal struct {
+	mu      sync.Mutex
+	counts  []uint64 // cumulative-friendly, one per bucket (non-cumulative, summed on render)
+	sum     float64
+	count   uint64
+	buckets []float64
+}
+
+// Counter registers (or fetches) a counter metric with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *counterVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.counters[name]; ok {
+		return c
+	}
+	c := &counterVec{help: help, labels: labels, values: make(map[string]*uint64val)}
+	r.counters[name] = c
+	return c
+}
+
+// Gauge registers (or fetches) a gauge metric with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *gaugeVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if g, ok := r.gauges[name]; ok {
+		return g
+	}
+	g := &gaugeVec{help: help, labels: labels, values: make(map[string]*int64val)}
+	r.gauges[name] = g
+	return g
+}
+
+// Histogram registers (or fetches) a histogram metric with explicit buckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, ok := r.histograms[name]; ok {
+		return h
+	}
+	h := &histogramVec{help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramVal)}
+	r.histograms[name] = h
+	return h
+}
+
+// DefaultDurationBuckets mirrors the Prometheus client default buckets, in seconds.
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func labelKey(values ...string) string {
+	return strings.Join(values, "\xff")
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *counterVec) Add(delta uint64, labelValues ...string) {
+	key := labelKey(labelValues...)
+	globalRegistryMu.Lock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &uint64val{}
+		c.values[key] = v
+	}
+	globalRegistryMu.Unlock()
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (c *counterVec) value(labelValues ...string) uint64 {
+	key := labelKey(labelValues...)
+	globalRegistryMu.Lock()
+	v, ok := c.values[key]
+	globalRegistryMu.Unlock()
+	if !ok {
+		return 0
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+func (g *gaugeVec) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+func (g *gaugeVec) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+func (g *gaugeVec) Set(val int64, labelValues ...string) {
+	key := labelKey(labelValues...)
+	globalRegistryMu.Lock()
+	v, ok := g.values[key]
+	if !ok {
+		v = &int64val{}
+		g.values[key] = v
+	}
+	globalRegistryMu.Unlock()
+	v.mu.Lock()
+	v.v = val
+	v.mu.Unlock()
+}
+
+func (g *gaugeVec) Add(delta int64, labelValues ...string) {
+	key := labelKey(labelValues...)
+	globalRegistryMu.Lock()
+	v, ok := g.values[key]
+	if !ok {
+		v = &int64val{}
+		g.values[key] = v
+	}
+	globalRegistryMu.Unlock()
+	v.mu.Lock()
+	v.v += delta
+	v.mu.Unlock()
+}
+
+func (h *histogramVec) Observe(val float64, labelValues ...string) {
+	key := labelKey(labelValues...)
+	globalRegistryMu.Lock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramVal{counts: make([]uint64, len(h.buckets)), buckets: h.buckets}
+		h.values[key] = v
+	}
+	globalRegistryMu.Unlock()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sum += val
+	v.count++
+	// counts is non-cumulative: only the narrowest bucket the observation
+	// falls into is incremented. WriteTo accumulates across buckets when
+	// rendering the `_bucket` series, per Prometheus exposition semantics.
+	for i, le := range v.buckets {
+		if val <= le {
+			v.counts[i]++
+			break
+		}
+	}
+}
+
+// globalRegistryMu guards the lazy-init map writes above; per-value mutexes
+// guard the counters/gauges/histograms themselves.
+var globalRegistryMu sync.Mutex
+
+// WriteTo renders the registry in Prometheus text exposition format.
+func (r *Registry) WriteTo(w *bufio.Writer) {
+	r.mu.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	r.mu.Unlock()
+	for _, fn := range collectors {
+		fn()
+	}
+
+	r.mu.Lock()
+	counterNames := make([]string, 0, len(r.counters))
+	for name := range r.counters {
+		counterNames = append(counterNames, name)
+	}
+	gaugeNames := make([]string, 0, len(r.gauges))
+	for name := range r.gauges {
+		gaugeNames = append(gaugeNames, name)
+	}
+	histNames := make([]string, 0, len(r.histograms))
+	for name := range r.histograms {
+		histNames = append(histNames, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(counterNames)
+	sort.Strings(gaugeNames)
+	sort.Strings(histNames)
+
+	for _, name := range counterNames {
+		c := r.counters[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, c.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", name)
+		// Snapshot keys *and* value pointers together while holding the
+		// lock: collecting keys alone and then indexing c.values[k] after
+		// unlocking races with concurrent Add calls inserting new label
+		// combinations into the same map.
+		globalRegistryMu.Lock()
+		keys := make([]string, 0, len(c.values))
+		vals := make([]*uint64val, 0, len(c.values))
+		for k, v := range c.values {
+			keys = append(keys, k)
+			vals = append(vals, v)
+		}
+		globalRegistryMu.Unlock()
+		sort.Sort(&counterEntries{keys: keys, vals: vals})
+		for i, k := range keys {
+			v := vals[i]
+			v.mu.Lock()
+			val := v.v
+			v.mu.Unlock()
+			fmt.Fprintf(w, "%s%s %d\n", name, labelString(c.labels, k), val)
+		}
+	}
+
+	for _, name := range gaugeNames {
+		g := r.gauges[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, g.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		globalRegistryMu.Lock()
+		keys := make([]string, 0, len(g.values))
+		vals := make([]*int64val, 0, len(g.values))
+		for k, v := range g.values {
+			keys = append(keys, k)
+			vals = append(vals, v)
+		}
+		globalRegistryMu.Unlock()
+		sort.Sort(&gaugeEntries{keys: keys, vals: vals})
+		for i, k := range keys {
+			v := vals[i]
+			v.mu.Lock()
+			val := v.v
+			v.mu.Unlock()
+			fmt.Fprintf(w, "%s%s %d\n", name, labelString(g.labels, k), val)
+		}
+	}
+
+	for _, name := range histNames {
+		h := r.histograms[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, h.help)
+		fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+		globalRegistryMu.Lock()
+		keys := make([]string, 0, len(h.values))
+		vals := make([]*histogramVal, 0, len(h.values))
+		for k, v := range h.values {
+			keys = append(keys, k)
+			vals = append(vals, v)
+		}
+		globalRegistryMu.Unlock()
+		sort.Sort(&histogramEntries{keys: keys, vals: vals})
+		for i, k := range keys {
+			v := vals[i]
+			v.mu.Lock()
+			var cumulative uint64
+			for i, le := range v.buckets {
+				cumulative += v.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelStringWithExtra(h.labels, k, "le", strconv.FormatFloat(le, 'g', -1, 64)), cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelStringWithExtra(h.labels, k, "le", "+Inf"), v.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", name, labelString(h.labels, k), strconv.FormatFloat(v.sum, 'g', -1, 64))
+			fmt.Fprintf(w, "%s_count%s %d\n", name, labelString(h.labels, k), v.count)
+			v.mu.Unlock()
+		}
+	}
+}
+
+// counterEntries/gaugeEntries/histogramEntries implement sort.Interface over
+// a (keys, value pointers) pair collected under globalRegistryMu, so WriteTo
+// can sort the snapshot for deterministic output without re-indexing the
+// source map (and thus racing a concurrent Add/Set/Observe) afterwards.
+type counterEntries struct {
+	keys []string
+	vals []*uint64val
+}
+
+func (e *counterEntries) Len() int           { return len(e.keys) }
+func (e *counterEntries) Less(i, j int) bool { return e.keys[i] < e.keys[j] }
+func (e *counterEntries) Swap(i, j int) {
+	e.keys[i], e.keys[j] = e.keys[j], e.keys[i]
+	e.vals[i], e.vals[j] = e.vals[j], e.vals[i]
+}
+
+type gaugeEntries struct {
+	keys []string
+	vals []*int64val
+}
+
+func (e *gaugeEntries) Len() int           { return len(e.keys) }
+func (e *gaugeEntries) Less(i, j int) bool { return e.keys[i] < e.keys[j] }
+func (e *gaugeEntries) Swap(i, j int) {
+	e.keys[i], e.keys[j] = e.keys[j], e.keys[i]
+	e.vals[i], e.vals[j] = e.vals[j], e.vals[i]
+}
+
+type histogramEntries struct {
+	keys []string
+	vals []*histogramVal
+}
+
+func (e *histogramEntries) Len() int           { return len(e.keys) }
+func (e *histogramEntries) Less(i, j int) bool { return e.keys[i] < e.keys[j] }
+func (e *histogramEntries) Swap(i, j int) {
+	e.keys[i], e.keys[j] = e.keys[j], e.keys[i]
+	e.vals[i], e.vals[j] = e.vals[j], e.vals[i]
+}
+
+func labelString(names []string, key string) string {
+	return labelStringWithExtra(names, key, "", "")
+}
+
+// labelStringWithExtra renders the `{name="value",...}` suffix for a metric
+// line, optionally appending one extra label (used for histogram `le`).
+func labelStringWithExtra(names []string, key, extraName, extraVal string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	parts := []string{}
+	if key != "" {
+		values := strings.Split(key, "\xff")
+		for i, name := range names {
+			if i < len(values) {
+				parts = append(parts, fmt.Sprintf("%s=%q", name, values[i]))
+			}
+		}
+	}
+	if extraName != "" {
+		parts = append(parts, fmt.Sprintf("%s=%q", extraName, extraVal))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Handler returns an http.Handler that serves the registry in exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		r.WriteTo(bw)
+		bw.Flush()
+	})
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code and
+// response size written, for use by metricsMiddleware.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.size += n
+	return n, err
+}
+
+// statusLabel maps status to its label value for the requestsTotal counter,
+// collapsing anything outside the registered HTTP status codes to "other" so
+// a handler that echoes a caller-controlled status (e.g. readyHandler's
+// ?status= override) can't blow up the metric's cardinality.
+func statusLabel(status int) string {
+	if http.StatusText(status) == "" {
+		return "other"
+	}
+	return strconv.Itoa(status)
+}
+
+// metricsMiddleware wraps every route in the mux to record request counts,
+// duration histograms, an in-flight gauge, and response sizes, labeled by
+// method, path template, and status code.
+func metricsMiddleware(reg *Registry, pathTemplate string, next http.HandlerFunc) http.HandlerFunc {
+	requestsTotal := reg.Counter("http_requests_total", "Total number of HTTP requests.", "method", "path", "status")
+	duration := reg.Histogram("http_request_duration_seconds", "HTTP request duration in seconds.", DefaultDurationBuckets, "method", "path")
+	inFlight := reg.Gauge("http_requests_in_flight", "Number of in-flight HTTP requests.")
+	responseSize := reg.Histogram("http_response_size_bytes", "HTTP response size in bytes.", []float64{100, 1000, 10000, 100000, 1000000}, "method", "path")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next(sr, r)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		elapsed := time.Since(start).Seconds()
+
+		requestsTotal.Inc(r.Method, pathTemplate, statusLabel(sr.status))
+		duration.Observe(elapsed, r.Method, pathTemplate)
+		responseSize.Observe(float64(sr.size), r.Method, pathTemplate)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestHistogramBucketsAreCumulativeAndMonotonic guards against the
+// double-accumulation bug where Observe and WriteTo both summed bucket
+// counts, producing non-monotonic `_bucket` series.
+func TestHistogramBucketsAreCumulativeAndMonotonic(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.Histogram("test_latency_seconds", "test histogram", []float64{0.005, 0.01, 0.025})
+	for i := 0; i < 3; i++ {
+		h.Observe(0.001)
+	}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	reg.WriteTo(w)
+	w.Flush()
+
+	bucketLine := regexp.MustCompile(`^test_latency_seconds_bucket\{le="([^"]+)"\} (\d+)$`)
+	countLine := regexp.MustCompile(`^test_latency_seconds_count (\d+)$`)
+
+	var buckets []uint64
+	var totalCount uint64
+	for _, line := range bytesLines(buf.Bytes()) {
+		if m := bucketLine.FindStringSubmatch(line); m != nil {
+			v, err := strconv.ParseUint(m[2], 10, 64)
+			if err != nil {
+				t.Fatalf("bad bucket value %q: %v", m[2], err)
+			}
+			buckets = append(buckets, v)
+		}
+		if m := countLine.FindStringSubmatch(line); m != nil {
+			v, err := strconv.ParseUint(m[1], 10, 64)
+			if err != nil {
+				t.Fatalf("bad count value %q: %v", m[1], err)
+			}
+			totalCount = v
+		}
+	}
+
+	if len(buckets) != 4 { // 3 explicit buckets + +Inf
+		t.Fatalf("expected 4 bucket lines (3 explicit + +Inf), got %d: %v", len(buckets), buckets)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] < buckets[i-1] {
+			t.Fatalf("bucket counts not monotonically non-decreasing: %v", buckets)
+		}
+	}
+	last := buckets[len(buckets)-1]
+	if last != totalCount {
+		t.Fatalf("+Inf bucket (%d) should equal _count (%d)", last, totalCount)
+	}
+	if last != 3 {
+		t.Fatalf("expected 3 observations total, got %d", last)
+	}
+}
+
+// TestWriteToConcurrentWithNewLabelCombinations guards against a data race
+// where WriteTo collected a vec's keys under globalRegistryMu but then
+// indexed the map again (c.values[k]) after releasing it, racing a
+// concurrent Add/Set/Observe call that inserts a new label combination into
+// the same map. Run with -race to catch a regression.
+func TestWriteToConcurrentWithNewLabelCombinations(t *testing.T) {
+	reg := NewRegistry()
+	counter := reg.Counter("test_requests_total", "test counter", "status")
+	gauge := reg.Gauge("test_in_flight", "test gauge")
+	hist := reg.Histogram("test_duration_seconds", "test histogram", DefaultDurationBuckets, "status")
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			status := fmt.Sprintf("%d", 200+i%50) // new label combination each time
+			counter.Inc(status)
+			gauge.Set(int64(i))
+			hist.Observe(0.01, status)
+		}
+	}()
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for i := 0; i < 200; i++ {
+		buf.Reset()
+		reg.WriteTo(w)
+		w.Flush()
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// TestStatusLabelBoundsCardinality guards against an unauthenticated caller
+// (e.g. readyHandler's ?status= override) driving unbounded cardinality on
+// http_requests_total by supplying an arbitrary integer status.
+func TestStatusLabelBoundsCardinality(t *testing.T) {
+	if got := statusLabel(http.StatusOK); got != "200" {
+		t.Fatalf("statusLabel(200) = %q, want \"200\"", got)
+	}
+	if got := statusLabel(http.StatusNotFound); got != "404" {
+		t.Fatalf("statusLabel(404) = %q, want \"404\"", got)
+	}
+	for _, bogus := range []int{0, -1, 999999, 123456789} {
+		if got := statusLabel(bogus); got != "other" {
+			t.Fatalf("statusLabel(%d) = %q, want \"other\"", bogus, got)
+		}
+	}
+}
+
+func bytesLines(b []byte) []string {
+	var lines []string
+	for _, l := range bytes.Split(b, []byte("\n")) {
+		if len(l) > 0 {
+			lines = append(lines, string(l))
+		}
+	}
+	return lines
+}
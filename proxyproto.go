@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// proxyProtoV2Signature is the fixed 12-byte preamble of a PROXY protocol v2
+// header, used to detect v2 framing before parsing the rest.
+var proxyProtoV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	proxyCmdLocal = 0x0
+	proxyCmdProxy = 0x1
+
+	proxyFamilyUnspec = 0x0
+	proxyFamilyInet   = 0x1
+	proxyFamilyInet6  = 0x2
+	proxyFamilyUnix   = 0x3
+)
+
+// proxyProtoListener wraps a net.Listener so every accepted connection is
+// peeked for a PROXY protocol v2 header before being handed to the HTTP
+// server, so real client IPs survive an L4 load balancer like HAProxy or an
+// AWS NLB.
+type proxyProtoListener struct {
+	net.Listener
+}
+
+// NewProxyProtoListener wraps l to decode PROXY protocol v2 headers.
+func NewProxyProtoListener(l net.Listener) net.Listener {
+	return &proxyProtoListener{Listener: l}
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		pc, err := newProxyProtoConn(conn)
+		if err != nil {
+			// A malformed PROXY header is a property of this one
+			// connection, not the listener: drop it and keep accepting.
+			// Returning the error here would propagate out of Serve as a
+			// fatal (non-temporary) Accept error and take the whole server
+			// down on a single bad client.
+			fmt.Fprintf(os.Stderr, "proxyproto: rejecting connection from %s: %v\n", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		return pc, nil
+	}
+}
+
+// proxyProtoConn wraps a net.Conn, replaying any bytes consumed while
+// parsing the PROXY header and reporting the decoded source address.
+type proxyProtoConn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+}
+
+// proxyProtoHeaderTimeout bounds how long Accept() will block reading a
+// PROXY header off a single connection, so a client that connects and then
+// sends nothing (or dribbles bytes) can't stall the whole accept loop.
+const proxyProtoHeaderTimeout = 5 * time.Second
+
+func newProxyProtoConn(conn net.Conn) (net.Conn, error) {
+	br := bufio.NewReaderSize(conn, 256)
+	pc := &proxyProtoConn{Conn: conn, r: br, remoteAddr: conn.RemoteAddr()}
+
+	if err := conn.SetReadDeadline(time.Now().Add(proxyProtoHeaderTimeout)); err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	sig, err := br.Peek(len(proxyProtoV2Signature))
+	if err != nil {
+		// Not enough bytes yet for even the signature (including a
+		// deadline timeout): treat as a connection with no PROXY header
+		// rather than blocking forever.
+		if errors.Is(err, io.EOF) {
+			return pc, nil
+		}
+		return pc, nil
+	}
+	for i, b := range proxyProtoV2Signature {
+		if sig[i] != b {
+			// No PROXY header present; pass the connection through as-is.
+			return pc, nil
+		}
+	}
+
+	addr, consumed, err := parseProxyV2Header(br)
+	if err != nil {
+		return nil, err
+	}
+	_ = consumed
+	if addr != nil {
+		pc.remoteAddr = addr
+	}
+	return pc, nil
+}
+
+// parseProxyV2Header reads and parses a full PROXY protocol v2 header from
+// r, returning the decoded source address (nil on a LOCAL command, which
+// carries no real peer info and is typically used for health checks).
+//
+// Header layout: 12-byte signature, 1-byte version/command, 1-byte address
+// family/protocol, 2-byte big-endian address-block length, then the address
+// block itself.
+func parseProxyV2Header(r *bufio.Reader) (net.Addr, int, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+
+	verCmd := header[12]
+	version := verCmd >> 4
+	command := verCmd & 0x0F
+	if version != 2 {
+		return nil, 0, errors.New("proxyproto: unsupported version")
+	}
+
+	family := header[13] >> 4
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, err
+	}
+
+	if command == proxyCmdLocal {
+		return nil, int(length) + 16, nil
+	}
+	if command != proxyCmdProxy {
+		return nil, 0, errors.New("proxyproto: unknown command")
+	}
+
+	switch family {
+	case proxyFamilyInet:
+		if len(payload) < 12 {
+			return nil, 0, errors.New("proxyproto: short TCP4 address block")
+		}
+		ip := net.IP(payload[0:4])
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return &net.TCPAddr{IP: ip, Port: int(port)}, int(length) + 16, nil
+	case proxyFamilyInet6:
+		if len(payload) < 36 {
+			return nil, 0, errors.New("proxyproto: short TCP6 address block")
+		}
+		ip := net.IP(payload[0:16])
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return &net.TCPAddr{IP: ip, Port: int(port)}, int(length) + 16, nil
+	case proxyFamilyUnix:
+		if len(payload) < 216 {
+			return nil, 0, errors.New("proxyproto: short UNIX address block")
+		}
+		return &net.UnixAddr{Name: trimNulls(payload[0:108]), Net: "unix"}, int(length) + 16, nil
+	default:
+		// AF_UNSPEC or unknown family: no address to decode, fall back.
+		return nil, int(length) + 16, nil
+	}
+}
+
+func trimNulls(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeConn is a minimal net.Conn backed by an in-memory buffer, used to
+// exercise newProxyProtoConn without a real socket.
+type fakeConn struct {
+	r    *bytes.Reader
+	addr net.Addr
+}
+
+func newFakeConn(data []byte) *fakeConn {
+	return &fakeConn{r: bytes.NewReader(data), addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}}
+}
+
+func (c *fakeConn) Read(b []byte) (int, error)         { return c.r.Read(b) }
+func (c *fakeConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (c *fakeConn) Close() error                       { return nil }
+func (c *fakeConn) LocalAddr() net.Addr                { return c.addr }
+func (c *fakeConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *fakeConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fakeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func buildV2Header(t *testing.T, cmd byte, family byte, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.Write(proxyProtoV2Signature)
+	buf.WriteByte(0x20 | cmd) // version 2, command
+	buf.WriteByte(family << 4)
+	buf.WriteByte(byte(len(payload) >> 8))
+	buf.WriteByte(byte(len(payload)))
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func TestParseProxyV2HeaderTCP4(t *testing.T) {
+	payload := make([]byte, 12)
+	copy(payload[0:4], net.ParseIP("10.1.2.3").To4())
+	copy(payload[4:8], net.ParseIP("10.1.2.4").To4())
+	payload[8], payload[9] = 0x1F, 0x90 // src port 8080
+	payload[10], payload[11] = 0x00, 0x50
+
+	header := buildV2Header(t, proxyCmdProxy, proxyFamilyInet, payload)
+	addr, consumed, err := parseProxyV2Header(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil {
+		t.Fatalf("parseProxyV2Header: %v", err)
+	}
+	if consumed != 16+len(payload) {
+		t.Fatalf("consumed = %d, want %d", consumed, 16+len(payload))
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		t.Fatalf("addr is %T, want *net.TCPAddr", addr)
+	}
+	if tcpAddr.IP.String() != "10.1.2.3" || tcpAddr.Port != 8080 {
+		t.Fatalf("got %s:%d, want 10.1.2.3:8080", tcpAddr.IP, tcpAddr.Port)
+	}
+}
+
+func TestParseProxyV2HeaderLocalCommandHasNoAddress(t *testing.T) {
+	header := buildV2Header(t, proxyCmdLocal, proxyFamilyUnspec, nil)
+	addr, _, err := parseProxyV2Header(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil {
+		t.Fatalf("parseProxyV2Header: %v", err)
+	}
+	if addr != nil {
+		t.Fatalf("LOCAL command should yield a nil address, got %v", addr)
+	}
+}
+
+// fakeListener hands out a fixed sequence of connections, one per Accept
+// call, then returns an io.EOF-like error once exhausted.
+type fakeListener struct {
+	conns []net.Conn
+	i     int
+	addr  net.Addr
+}
+
+func (l *fakeListener) Accept() (net.Conn, error) {
+	if l.i >= len(l.conns) {
+		return nil, errors.New("fakeListener: exhausted")
+	}
+	c := l.conns[l.i]
+	l.i++
+	return c, nil
+}
+func (l *fakeListener) Close() error   { return nil }
+func (l *fakeListener) Addr() net.Addr { return l.addr }
+
+func TestProxyProtoListenerAcceptSkipsMalformedHeader(t *testing.T) {
+	malformed := buildV2Header(t, 0x5 /* bogus command */, proxyFamilyInet, nil)
+	good := buildV2Header(t, proxyCmdLocal, proxyFamilyUnspec, nil)
+
+	inner := &fakeListener{conns: []net.Conn{newFakeConn(malformed), newFakeConn(good)}}
+	l := NewProxyProtoListener(inner)
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept should skip the malformed connection and return the next one, got error: %v", err)
+	}
+	if conn == nil {
+		t.Fatalf("Accept returned a nil connection")
+	}
+}
+
+func TestNewProxyProtoConnFallsBackWithoutSignature(t *testing.T) {
+	conn := newFakeConn([]byte("GET / HTTP/1.1\r\n\r\n"))
+	wrapped, err := newProxyProtoConn(conn)
+	if err != nil {
+		t.Fatalf("newProxyProtoConn: %v", err)
+	}
+	if wrapped.RemoteAddr() != conn.RemoteAddr() {
+		t.Fatalf("expected RemoteAddr to pass through unchanged when no PROXY header is present")
+	}
+	buf := make([]byte, 3)
+	n, err := wrapped.Read(buf)
+	if err != nil || string(buf[:n]) != "GET" {
+		t.Fatalf("Read after fallback = %q, %v; want \"GET\", nil", buf[:n], err)
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span's position in a trace, per the W3C Trace
+// Context spec (https://www.w3.org/TR/trace-context/).
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+var traceparentRE = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// parseTraceparent parses a W3C `traceparent` header value. It does not
+// interpret `tracestate`, and tracingMiddleware neither reads nor forwards
+// that header.
+func parseTraceparent(header string) (SpanContext, bool) {
+	m := traceparentRE.FindStringSubmatch(header)
+	if m == nil {
+		return SpanContext{}, false
+	}
+	if m[1] != "00" {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	traceID, err := hex.DecodeString(m[2])
+	if err != nil || allZero(traceID) {
+		return SpanContext{}, false
+	}
+	spanID, err := hex.DecodeString(m[3])
+	if err != nil || allZero(spanID) {
+		return SpanContext{}, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	flags, err := hex.DecodeString(m[4])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&0x01 == 1
+	return sc, true
+}
+
+func allZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// formatTraceparent renders sc as a W3C `traceparent` header value.
+func formatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+func newTraceID() [16]byte {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return b
+}
+
+func newSpanID() [8]byte {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return b
+}
+
+// SpanData is the exported, read-only view of a finished span.
+type SpanData struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_span_id,omitempty"`
+	StartUnix  int64             `json:"start_unix_nano"`
+	EndUnix    int64             `json:"end_unix_nano"`
+	StatusCode string            `json:"status_code"`
+	StatusMsg  string            `json:"status_message,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Exporter ships finished spans somewhere: stdout for local debugging, or an
+// OTLP collector over HTTP.
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+// noopExporter drops spans; used when OTEL_EXPORTER_OTLP_ENDPOINT is unset.
+type noopExporter struct{}
+
+func (noopExporter) Export([]SpanData) error { return nil }
+
+// otlpHTTPExporter posts spans to a collector's OTLP/HTTP endpoint using the
+// OTLP JSON encoding (the protobuf-JSON mapping of
+// opentelemetry.proto.collector.trace.v1.ExportTraceServiceRequest), so any
+// collector with an OTLP/HTTP receiver can ingest it without a proprietary
+// shim. OTLP/gRPC is not implemented (it needs a protobuf/gRPC stack).
+type otlpHTTPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+func newOTLPHTTPExporter(endpoint, serviceName string) *otlpHTTPExporter {
+	return &otlpHTTPExporter{endpoint: endpoint, serviceName: serviceName, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// OTLP span kind and status code enums (subset of
+// opentelemetry.proto.trace.v1), used verbatim in the JSON mapping.
+const (
+	otlpSpanKindInternal = 1
+
+	otlpStatusCodeUnset = 0
+	otlpStatusCodeOK    = 1
+	otlpStatusCodeError = 2
+)
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource    `json:"resource"`
+	ScopeSpans []otlpScopeSpan `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpan struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// otlpIDBytes decodes a hex-encoded trace/span ID into the base64 encoding
+// OTLP JSON uses for proto `bytes` fields. An invalid or empty hex string
+// (e.g. SpanData.ParentID on a root span) yields "".
+func otlpIDBytes(hexID string) string {
+	if hexID == "" {
+		return ""
+	}
+	raw, err := hex.DecodeString(hexID)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func otlpStatusFrom(data SpanData) otlpStatus {
+	code := otlpStatusCodeUnset
+	switch data.StatusCode {
+	case "ok":
+		code = otlpStatusCodeOK
+	case "error":
+		code = otlpStatusCodeError
+	}
+	return otlpStatus{Code: code, Message: data.StatusMsg}
+}
+
+func otlpAttributesFrom(attrs map[string]string) []otlpKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: attrs[k]}})
+	}
+	return kvs
+}
+
+func (e *otlpHTTPExporter) exportRequest(spans []SpanData) otlpExportRequest {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		otlpSpans = append(otlpSpans, otlpSpan{
+			TraceID:           otlpIDBytes(s.TraceID),
+			SpanID:            otlpIDBytes(s.SpanID),
+			ParentSpanID:      otlpIDBytes(s.ParentID),
+			Name:              s.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.StartUnix, 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndUnix, 10),
+			Attributes:        otlpAttributesFrom(s.Attributes),
+			Status:            otlpStatusFrom(s),
+		})
+	}
+	return otlpExportRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: otlpAnyValue{StringValue: e.serviceName}},
+		}},
+		ScopeSpans: []otlpScopeSpan{{
+			Scope: otlpScope{Name: "deepsight"},
+			Spans: otlpSpans,
+		}},
+	}}}
+}
+
+func (e *otlpHTTPExporter) Export(spans []SpanData) error {
+	body, err := json.Marshal(e.exportRequest(spans))
+	if err != nil {
+		return err
+	}
+	url := e.endpoint + "/v1/traces"
+	resp, err := e.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp exporter: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// exporterFromEnv builds an Exporter based on OTEL_EXPORTER_OTLP_ENDPOINT.
+// OTLP/gRPC is not implemented (it needs a protobuf/gRPC stack); when the
+// endpoint is set we always speak OTLP/HTTP, identifying this process as
+// serviceName in the exported resource. The result is wrapped in an
+// asyncExporter so the request path never blocks on a collector round trip.
+func exporterFromEnv(serviceName string) Exporter {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return noopExporter{}
+	}
+	return newAsyncExporter(newOTLPHTTPExporter(endpoint, serviceName), asyncExporterQueueSize, asyncExporterBatchSize, asyncExporterFlushInterval)
+}
+
+const (
+	asyncExporterQueueSize     = 1024
+	asyncExporterBatchSize     = 64
+	asyncExporterFlushInterval = 2 * time.Second
+)
+
+// asyncExporter decouples span export from the request path: Export enqueues
+// spans onto a bounded channel and returns immediately, dropping spans if
+// the queue is full rather than applying backpressure to handlers. A
+// background goroutine batches queued spans and flushes them to the
+// underlying exporter on a timer or once a batch fills up.
+type asyncExporter struct {
+	inner     Exporter
+	queue     chan SpanData
+	batchSize int
+}
+
+// newAsyncExporter wraps inner with a bounded, background-flushed queue.
+func newAsyncExporter(inner Exporter, queueSize, batchSize int, flushInterval time.Duration) *asyncExporter {
+	e := &asyncExporter{inner: inner, queue: make(chan SpanData, queueSize), batchSize: batchSize}
+	go e.run(flushInterval)
+	return e
+}
+
+// Export never blocks: it drops the span (and logs once per drop) if the
+// queue is full rather than stalling the caller's request.
+func (e *asyncExporter) Export(spans []SpanData) error {
+	for _, s := range spans {
+		select {
+		case e.queue <- s:
+		default:
+			fmt.Fprintf(os.Stderr, "tracing: export queue full, dropping span %s/%s\n", s.TraceID, s.SpanID)
+		}
+	}
+	return nil
+}
+
+func (e *asyncExporter) run(flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, e.batchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.inner.Export(batch); err != nil {
+			fmt.Fprintf(os.Stderr, "tracing: export failed: %v\n", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// traceSampleRatio is the fraction of root traces sampled (child spans
+// always inherit their parent's decision). Configured via
+// OTEL_TRACES_SAMPLER_ARG, a float in [0,1]; defaults to 1.0 (sample all).
+func traceSampleRatio() float64 {
+	v := os.Getenv("OTEL_TRACES_SAMPLER_ARG")
+	if v == "" {
+		return 1.0
+	}
+	ratio, err := strconv.ParseFloat(v, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return 1.0
+	}
+	return ratio
+}
+
+// shouldSample makes a deterministic sampling decision from the trace ID, so
+// that all spans of the same trace agree regardless of which process makes
+// the call (no shared coordination needed).
+func shouldSample(traceID [16]byte, ratio float64) bool {
+	if ratio >= 1.0 {
+		return true
+	}
+	if ratio <= 0.0 {
+		return false
+	}
+	threshold := uint64(ratio * float64(^uint64(0)))
+	return binary.BigEndian.Uint64(traceID[:8]) <= threshold
+}
+
+// Span represents one in-flight unit of work within a trace.
+type Span struct {
+	name       string
+	ctx        SpanContext
+	parentID   [8]byte
+	start      time.Time
+	tracer     *Tracer
+	mu         sync.Mutex
+	status     string
+	statusMsg  string
+	attributes map[string]string
+}
+
+// SetStatus records the outcome of the span; status is typically "ok" or
+// "error".
+func (s *Span) SetStatus(status, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+	s.statusMsg = message
+}
+
+// SetAttribute attaches a string attribute to the span.
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = map[string]string{}
+	}
+	s.attributes[key] = value
+}
+
+// TraceID returns the span's trace ID as a lowercase hex string, suitable
+// for correlating with logs or surfacing in a UI.
+func (s *Span) TraceID() string {
+	return hex.EncodeToString(s.ctx.TraceID[:])
+}
+
+// End finalizes the span and hands it to the tracer's exporter.
+func (s *Span) End() {
+	s.mu.Lock()
+	status, msg, attrs := s.status, s.statusMsg, s.attributes
+	if status == "" {
+		status = "ok"
+	}
+	s.mu.Unlock()
+
+	data := SpanData{
+		Name:       s.name,
+		TraceID:    s.TraceID(),
+		SpanID:     hex.EncodeToString(s.ctx.SpanID[:]),
+		StartUnix:  s.start.UnixNano(),
+		EndUnix:    time.Now().UnixNano(),
+		StatusCode: status,
+		StatusMsg:  msg,
+		Attributes: attrs,
+	}
+	if !allZero(s.parentID[:]) {
+		data.ParentID = hex.EncodeToString(s.parentID[:])
+	}
+	s.tracer.export(data, s.ctx.Sampled)
+}
+
+// Tracer creates spans and forwards finished ones to an Exporter.
+type Tracer struct {
+	serviceName string
+	exporter    Exporter
+	sampleRatio float64
+}
+
+// NewTracer creates a Tracer that exports via exp, sampling root traces at
+// the ratio configured via OTEL_TRACES_SAMPLER_ARG.
+func NewTracer(serviceName string, exp Exporter) *Tracer {
+	return &Tracer{serviceName: serviceName, exporter: exp, sampleRatio: traceSampleRatio()}
+}
+
+// export enqueues data with the exporter (expected to be non-blocking, e.g.
+// an asyncExporter) unless the span wasn't sampled.
+func (t *Tracer) export(data SpanData, sampled bool) {
+	if !sampled {
+		return
+	}
+	if err := t.exporter.Export([]SpanData{data}); err != nil {
+		fmt.Fprintf(os.Stderr, "tracing: export failed: %v\n", err)
+	}
+}
+
+type spanContextKey struct{}
+
+// StartSpan starts a new span named name, becoming a child of any span
+// found in ctx (or of a remote parent propagated via context, see
+// tracingMiddleware) and returns the span alongside a context carrying it.
+func (t *Tracer) StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	var parentID [8]byte
+	sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}
+	if parent, ok := ctx.Value(spanContextKey{}).(SpanContext); ok {
+		// Child spans always honor the parent's (possibly remote) sampling
+		// decision, so a trace is never partially sampled.
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		parentID = parent.SpanID
+	} else {
+		sc.Sampled = shouldSample(sc.TraceID, t.sampleRatio)
+	}
+	span := &Span{name: name, ctx: sc, parentID: parentID, start: time.Now(), tracer: t}
+	return context.WithValue(ctx, spanContextKey{}, sc), span
+}
+
+// SpanFromContext returns the SpanContext of the current span and true, or
+// false if no span is active.
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// tracingMiddleware extracts an inbound W3C traceparent (falling back to a
+// fresh trace), starts a server span per route, records status/error, and
+// propagates the span through r.Context() so downstream code — and
+// dashboardHandler's trace-ID readout — can see it.
+func tracingMiddleware(tracer *Tracer, routeName string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = context.WithValue(ctx, spanContextKey{}, sc)
+		}
+
+		ctx, span := tracer.StartSpan(ctx, "HTTP "+r.Method+" "+routeName)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", routeName)
+
+		w.Header().Set("traceparent", formatTraceparent(span.ctx))
+
+		sr := &statusRecorder{ResponseWriter: w}
+		next(sr, r.WithContext(ctx))
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", fmt.Sprintf("%d", sr.status))
+		if sr.status >= 500 {
+			span.SetStatus("error", fmt.Sprintf("handler returned %d", sr.status))
+		} else {
+			span.SetStatus("ok", "")
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTraceparentRoundTrip(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := parseTraceparent(header)
+	if !ok {
+		t.Fatalf("parseTraceparent(%q) failed to parse a valid header", header)
+	}
+	if !sc.Sampled {
+		t.Fatalf("expected Sampled=true for flags 01")
+	}
+	if got := formatTraceparent(sc); got != header {
+		t.Fatalf("round trip mismatch: got %q, want %q", got, header)
+	}
+}
+
+func TestParseTraceparentRejectsInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"not-a-traceparent",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", // unsupported version
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", // all-zero trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", // all-zero span ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",  // short flags
+	}
+	for _, c := range cases {
+		if _, ok := parseTraceparent(c); ok {
+			t.Errorf("parseTraceparent(%q) unexpectedly succeeded", c)
+		}
+	}
+}
+
+func TestShouldSampleIsDeterministicPerTraceID(t *testing.T) {
+	var id [16]byte
+	for i := range id {
+		id[i] = byte(i)
+	}
+	first := shouldSample(id, 0.5)
+	for i := 0; i < 10; i++ {
+		if shouldSample(id, 0.5) != first {
+			t.Fatalf("shouldSample is not deterministic for a fixed trace ID")
+		}
+	}
+	if !shouldSample(id, 1.0) {
+		t.Fatalf("ratio 1.0 should always sample")
+	}
+	if shouldSample(id, 0.0) {
+		t.Fatalf("ratio 0.0 should never sample")
+	}
+}
+
+func TestAsyncExporterFlushesBatches(t *testing.T) {
+	var got []SpanData
+	done := make(chan struct{})
+	inner := exporterFunc(func(spans []SpanData) error {
+		got = append(got, spans...)
+		if len(got) >= 2 {
+			close(done)
+		}
+		return nil
+	})
+
+	e := newAsyncExporter(inner, 16, 2, time.Second)
+	_ = e.Export([]SpanData{{TraceID: "t1", SpanID: "s1"}, {TraceID: "t1", SpanID: "s2"}})
+
+	<-done
+	if len(got) != 2 {
+		t.Fatalf("expected 2 spans flushed, got %d", len(got))
+	}
+}
+
+type exporterFunc func(spans []SpanData) error
+
+func (f exporterFunc) Export(spans []SpanData) error { return f(spans) }
+
+// TestOTLPExportRequestShape guards against the exporter shipping a bespoke
+// {"spans":[...]} body instead of the real OTLP/HTTP JSON encoding
+// (ExportTraceServiceRequest): trace/span IDs must be base64 (proto `bytes`
+// JSON mapping), not hex, and nested under resourceSpans/scopeSpans/spans.
+func TestOTLPExportRequestShape(t *testing.T) {
+	e := newOTLPHTTPExporter("http://example.invalid", "deepsight")
+	data := SpanData{
+		Name:       "HTTP GET /ready",
+		TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:     "00f067aa0ba902b7",
+		StartUnix:  1000,
+		EndUnix:    2000,
+		StatusCode: "ok",
+		Attributes: map[string]string{"http.method": "GET"},
+	}
+	req := e.exportRequest([]SpanData{data})
+
+	if len(req.ResourceSpans) != 1 {
+		t.Fatalf("expected 1 ResourceSpans entry, got %d", len(req.ResourceSpans))
+	}
+	rs := req.ResourceSpans[0]
+	if len(rs.Resource.Attributes) != 1 || rs.Resource.Attributes[0].Key != "service.name" || rs.Resource.Attributes[0].Value.StringValue != "deepsight" {
+		t.Fatalf("expected resource attributes to carry service.name=deepsight, got %+v", rs.Resource.Attributes)
+	}
+	if len(rs.ScopeSpans) != 1 || len(rs.ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("expected exactly 1 scope span with 1 span")
+	}
+	span := rs.ScopeSpans[0].Spans[0]
+
+	wantTraceID := "S/kvNXezTaajzpKdDg5HNg=="
+	if span.TraceID != wantTraceID {
+		t.Fatalf("traceId = %q, want base64 %q (not hex)", span.TraceID, wantTraceID)
+	}
+	wantSpanID := "APBnqgupArc="
+	if span.SpanID != wantSpanID {
+		t.Fatalf("spanId = %q, want base64 %q (not hex)", span.SpanID, wantSpanID)
+	}
+	if span.StartTimeUnixNano != "1000" || span.EndTimeUnixNano != "2000" {
+		t.Fatalf("unexpected timestamps: start=%q end=%q", span.StartTimeUnixNano, span.EndTimeUnixNano)
+	}
+	if span.Status.Code != otlpStatusCodeOK {
+		t.Fatalf("status code = %d, want %d (OK)", span.Status.Code, otlpStatusCodeOK)
+	}
+}